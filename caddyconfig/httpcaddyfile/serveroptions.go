@@ -17,12 +17,13 @@ package httpcaddyfile
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 
+	"github.com/dustin/go-humanize"
 	"github.com/uptimerobot/caddy/v2"
 	"github.com/uptimerobot/caddy/v2/caddyconfig"
 	"github.com/uptimerobot/caddy/v2/caddyconfig/caddyfile"
 	"github.com/uptimerobot/caddy/v2/modules/caddyhttp"
-	"github.com/dustin/go-humanize"
 )
 
 // serverOptions collects server config overrides parsed from Caddyfile global options
@@ -33,15 +34,25 @@ type serverOptions struct {
 	ListenerAddress string
 
 	// These will all map 1:1 to the caddyhttp.Server struct
-	ListenerWrappersRaw []json.RawMessage
-	ReadTimeout         caddy.Duration
-	ReadHeaderTimeout   caddy.Duration
-	WriteTimeout        caddy.Duration
-	IdleTimeout         caddy.Duration
-	MaxHeaderBytes      int
-	AllowH2C            bool
-	ExperimentalHTTP3   bool
-	StrictSNIHost       *bool
+	ListenerWrappersRaw     []json.RawMessage
+	ReadTimeout             caddy.Duration
+	ReadHeaderTimeout       caddy.Duration
+	WriteTimeout            caddy.Duration
+	IdleTimeout             caddy.Duration
+	MaxHeaderBytes          int
+	AllowH2C                bool
+	ExperimentalHTTP3       bool
+	StrictSNIHost           *bool
+	TrustedProxiesRaw       json.RawMessage
+	ClientIPHeaders         []string
+	Protocols               []string
+	QUIC                    *caddyhttp.QUICConfig
+	Metrics                 *caddyhttp.MetricsConfig
+	Tracing                 *caddyhttp.TracingConfig
+	DrainTimeout            caddy.Duration
+	MaxConnections          int
+	MaxConnectionsPerIP     int
+	MaxConnectionsPerIPCode int
 }
 
 func unmarshalCaddyfileServerOptions(d *caddyfile.Dispenser) (interface{}, error) {
@@ -130,6 +141,138 @@ func unmarshalCaddyfileServerOptions(d *caddyfile.Dispenser) (interface{}, error
 					}
 				}
 
+			case "trusted_proxies":
+				if !d.NextArg() {
+					return nil, d.ArgErr()
+				}
+				modName := d.Val()
+				mod, err := caddy.GetModule("http.ip_sources." + modName)
+				if err != nil {
+					return nil, fmt.Errorf("finding ip_sources module '%s': %v", modName, err)
+				}
+				unm, ok := mod.New().(caddyfile.Unmarshaler)
+				if !ok {
+					return nil, fmt.Errorf("ip_sources module '%s' is not a Caddyfile unmarshaler", mod)
+				}
+				err = unm.UnmarshalCaddyfile(d.NewFromNextSegment())
+				if err != nil {
+					return nil, err
+				}
+				ipSource, ok := unm.(caddyhttp.IPRangeSource)
+				if !ok {
+					return nil, fmt.Errorf("module %s is not an IP range source", mod)
+				}
+				serverOpts.TrustedProxiesRaw = caddyconfig.JSONModuleObject(
+					ipSource,
+					"source",
+					ipSource.(caddy.Module).CaddyModule().ID.Name(),
+					nil,
+				)
+
+			case "client_ip_headers":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return nil, d.ArgErr()
+				}
+				serverOpts.ClientIPHeaders = args
+
+			case "metrics":
+				if serverOpts.Metrics == nil {
+					serverOpts.Metrics = new(caddyhttp.MetricsConfig)
+				}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					switch d.Val() {
+					case "per_host":
+						if d.NextArg() {
+							return nil, d.ArgErr()
+						}
+						serverOpts.Metrics.PerHost = true
+
+					case "buckets":
+						args := d.RemainingArgs()
+						if len(args) == 0 {
+							return nil, d.ArgErr()
+						}
+						buckets := make([]float64, 0, len(args))
+						for _, arg := range args {
+							f, err := strconv.ParseFloat(arg, 64)
+							if err != nil {
+								return nil, d.Errf("parsing bucket value '%s': %v", arg, err)
+							}
+							buckets = append(buckets, f)
+						}
+						serverOpts.Metrics.Buckets = buckets
+
+					default:
+						return nil, d.Errf("unrecognized metrics option '%s'", d.Val())
+					}
+				}
+
+			case "tracing":
+				if serverOpts.Tracing == nil {
+					serverOpts.Tracing = new(caddyhttp.TracingConfig)
+				}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					switch d.Val() {
+					case "otlp_endpoint":
+						if !d.AllArgs(&serverOpts.Tracing.OtlpEndpoint) {
+							return nil, d.ArgErr()
+						}
+
+					case "sampler":
+						if !d.AllArgs(&serverOpts.Tracing.Sampler) {
+							return nil, d.ArgErr()
+						}
+
+					case "service_name":
+						if !d.AllArgs(&serverOpts.Tracing.ServiceName) {
+							return nil, d.ArgErr()
+						}
+
+					default:
+						return nil, d.Errf("unrecognized tracing option '%s'", d.Val())
+					}
+				}
+
+			case "drain_timeout":
+				if !d.NextArg() {
+					return nil, d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return nil, d.Errf("parsing drain_timeout duration: %v", err)
+				}
+				serverOpts.DrainTimeout = caddy.Duration(dur)
+
+			case "max_connections":
+				var numStr string
+				if !d.AllArgs(&numStr) {
+					return nil, d.ArgErr()
+				}
+				num, err := strconv.Atoi(numStr)
+				if err != nil {
+					return nil, d.Errf("parsing max_connections: %v", err)
+				}
+				serverOpts.MaxConnections = num
+
+			case "max_connections_per_ip":
+				args := d.RemainingArgs()
+				if len(args) < 1 || len(args) > 2 {
+					return nil, d.ArgErr()
+				}
+				num, err := strconv.Atoi(args[0])
+				if err != nil {
+					return nil, d.Errf("parsing max_connections_per_ip: %v", err)
+				}
+				serverOpts.MaxConnectionsPerIP = num
+				if len(args) == 2 {
+					code, err := strconv.Atoi(args[1])
+					if err != nil {
+						return nil, d.Errf("parsing max_connections_per_ip status code: %v", err)
+					}
+					serverOpts.MaxConnectionsPerIPCode = code
+				}
+
 			case "max_header_size":
 				var sizeStr string
 				if !d.AllArgs(&sizeStr) {
@@ -163,6 +306,76 @@ func unmarshalCaddyfileServerOptions(d *caddyfile.Dispenser) (interface{}, error
 						trueBool := true
 						serverOpts.StrictSNIHost = &trueBool
 
+					case "protocols":
+						args := d.RemainingArgs()
+						if len(args) == 0 {
+							return nil, d.ArgErr()
+						}
+						for _, proto := range args {
+							switch proto {
+							case "h1", "h2", "h2c", "h3":
+							default:
+								return nil, d.Errf("unrecognized protocol '%s'", proto)
+							}
+						}
+						serverOpts.Protocols = args
+
+					case "quic":
+						if serverOpts.QUIC == nil {
+							serverOpts.QUIC = new(caddyhttp.QUICConfig)
+						}
+						for nesting := d.Nesting(); d.NextBlock(nesting); {
+							switch d.Val() {
+							case "max_streams":
+								var streamsStr string
+								if !d.AllArgs(&streamsStr) {
+									return nil, d.ArgErr()
+								}
+								streams, err := strconv.Atoi(streamsStr)
+								if err != nil {
+									return nil, d.Errf("parsing max_streams: %v", err)
+								}
+								serverOpts.QUIC.MaxStreams = streams
+
+							case "initial_stream_window":
+								var sizeStr string
+								if !d.AllArgs(&sizeStr) {
+									return nil, d.ArgErr()
+								}
+								size, err := humanize.ParseBytes(sizeStr)
+								if err != nil {
+									return nil, d.Errf("parsing initial_stream_window: %v", err)
+								}
+								serverOpts.QUIC.InitialStreamWindow = size
+
+							case "initial_conn_window":
+								var sizeStr string
+								if !d.AllArgs(&sizeStr) {
+									return nil, d.ArgErr()
+								}
+								size, err := humanize.ParseBytes(sizeStr)
+								if err != nil {
+									return nil, d.Errf("parsing initial_conn_window: %v", err)
+								}
+								serverOpts.QUIC.InitialConnWindow = size
+
+							case "disable_0rtt":
+								if d.NextArg() {
+									return nil, d.ArgErr()
+								}
+								serverOpts.QUIC.Disable0RTT = true
+
+							case "allow_early_data":
+								if d.NextArg() {
+									return nil, d.ArgErr()
+								}
+								serverOpts.QUIC.AllowEarlyData = true
+
+							default:
+								return nil, d.Errf("unrecognized quic option '%s'", d.Val())
+							}
+						}
+
 					default:
 						return nil, d.Errf("unrecognized protocol option '%s'", d.Val())
 					}
@@ -229,6 +442,16 @@ func applyServerOptions(
 		server.AllowH2C = opts.AllowH2C
 		server.ExperimentalHTTP3 = opts.ExperimentalHTTP3
 		server.StrictSNIHost = opts.StrictSNIHost
+		server.TrustedProxiesRaw = opts.TrustedProxiesRaw
+		server.ClientIPHeaders = opts.ClientIPHeaders
+		server.Protocols = opts.Protocols
+		server.QUIC = opts.QUIC
+		server.Metrics = opts.Metrics
+		server.Tracing = opts.Tracing
+		server.DrainTimeout = opts.DrainTimeout
+		server.MaxConnections = opts.MaxConnections
+		server.MaxConnectionsPerIP = opts.MaxConnectionsPerIP
+		server.MaxConnectionsPerIPCode = opts.MaxConnectionsPerIPCode
 	}
 
 	return nil
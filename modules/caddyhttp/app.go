@@ -0,0 +1,99 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddyhttp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/uptimerobot/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(App{})
+}
+
+// App is the "http" Caddy app: it provisions and runs every server
+// named in Servers for the lifetime of the Caddy instance. This is the
+// module that turns the "servers" the Caddyfile adapter produces (see
+// httpcaddyfile/serveroptions.go) into servers that actually accept
+// connections.
+type App struct {
+	// Servers is the set of servers to provision and run, keyed by a
+	// name used only for error messages.
+	Servers map[string]*Server `json:"servers,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (App) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http",
+		New: func() caddy.Module { return new(App) },
+	}
+}
+
+// Provision sets up every configured server.
+func (a *App) Provision(ctx caddy.Context) error {
+	for name, srv := range a.Servers {
+		if err := srv.Provision(ctx); err != nil {
+			return fmt.Errorf("provisioning server '%s': %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Start binds listeners for, and begins serving, every configured
+// server. It returns once all of them have been launched, not once
+// they've stopped.
+func (a *App) Start() error {
+	for name, srv := range a.Servers {
+		if err := srv.Serve(); err != nil {
+			return fmt.Errorf("starting server '%s': %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Stop gracefully shuts every configured server down, honoring each
+// server's own DrainTimeout, and waits for all of them to finish before
+// returning.
+func (a *App) Stop() error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(a.Servers))
+
+	for _, srv := range a.Servers {
+		wg.Add(1)
+		go func(srv *Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(context.Background()); err != nil {
+				errs <- err
+			}
+		}(srv)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		return err
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.App         = (*App)(nil)
+	_ caddy.Provisioner = (*App)(nil)
+)
@@ -0,0 +1,225 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddyhttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// connLimitCtxKeyType is the context key under which a connection's
+// "over the per-IP limit" verdict is stashed by Server.ConnContext, for
+// enforceConnectionLimits to act on once the request reaches the HTTP
+// layer (where a status code can actually be written).
+type connLimitCtxKeyType struct{}
+
+var connLimitCtxKey = connLimitCtxKeyType{}
+
+// wrapListener wraps l so that every accepted connection is registered
+// with s.conns, honoring MaxConnections and MaxConnectionsPerIP. A
+// connection that would exceed MaxConnections is closed immediately, as
+// there is no HTTP request yet to respond to. A connection that would
+// only exceed MaxConnectionsPerIP is still accepted (so the client gets
+// a real HTTP response) but is flagged for enforceConnectionLimits, via
+// ConnContext, to reject at the first request.
+func (s *Server) wrapListener(l net.Listener) net.Listener {
+	return &limitedListener{Listener: l, server: s}
+}
+
+type limitedListener struct {
+	net.Listener
+	server *Server
+}
+
+func (l *limitedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if l.server.draining.Load() {
+			conn.Close()
+			continue
+		}
+
+		host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+		if err != nil {
+			host = conn.RemoteAddr().String()
+		}
+
+		overPerIPLimit := l.server.overPerIPLimit(host)
+
+		release, ok := l.server.conns.tryAdd(host, l.server.MaxConnections, 0)
+		if !ok {
+			// Over the total connection cap: there's no request yet to
+			// respond to, so just refuse the connection outright.
+			conn.Close()
+			continue
+		}
+
+		return &trackedConn{Conn: conn, release: release, overPerIPLimit: overPerIPLimit}, nil
+	}
+}
+
+// overPerIPLimit reports whether host is already at (or over)
+// MaxConnectionsPerIP, without registering a new connection for it.
+// The per-IP registration itself happens in the tryAdd call in
+// limitedListener.Accept, which counts the connection against its IP
+// without enforcing the per-IP limit; this check only decides whether
+// to flag the connection for rejection at the HTTP layer.
+func (s *Server) overPerIPLimit(host string) bool {
+	if s.MaxConnectionsPerIP <= 0 {
+		return false
+	}
+	return s.conns.perIPCount(host) >= s.MaxConnectionsPerIP
+}
+
+// trackedConn releases its connTracker slot when closed, and carries
+// whether it was over the per-IP limit at accept time so that value can
+// be attached to the request context via Server.ConnContext.
+type trackedConn struct {
+	net.Conn
+	release        func()
+	overPerIPLimit bool
+	released       bool
+}
+
+func (c *trackedConn) Close() error {
+	if !c.released {
+		c.released = true
+		c.release()
+	}
+	return c.Conn.Close()
+}
+
+// Unwrap exposes the underlying connection, so that other listener
+// wrappers' ConnContext implementations (e.g. proxyprotocol's, which
+// walks the wrap chain looking for its own conn type) still see through
+// trackedConn to whatever it wraps.
+func (c *trackedConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// ConnContext should be installed as the http.Server's ConnContext hook.
+// It first gives every configured listener_wrappers entry that
+// implements ConnContextProvider a chance to stash its own state on the
+// context -- since http.Server only accepts one ConnContext callback,
+// this is the one place all of them run -- then carries a trackedConn's
+// per-IP-limit verdict onto the request context so
+// enforceConnectionLimits can act on it.
+func (s *Server) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	for _, w := range s.listenerWrappers {
+		if provider, ok := w.(ConnContextProvider); ok {
+			ctx = provider.ConnContext(ctx, c)
+		}
+	}
+	if tc, ok := c.(*trackedConn); ok && tc.overPerIPLimit {
+		return context.WithValue(ctx, connLimitCtxKey, true)
+	}
+	return ctx
+}
+
+// enforceConnectionLimits is HTTP middleware that rejects, with
+// MaxConnectionsPerIPCode (default 429), any request arriving on a
+// connection that Server.ConnContext flagged as exceeding
+// MaxConnectionsPerIP -- before the request ever reaches next.
+func (s *Server) enforceConnectionLimits(next http.Handler) http.Handler {
+	code := s.MaxConnectionsPerIPCode
+	if code == 0 {
+		code = http.StatusTooManyRequests
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if over, _ := r.Context().Value(connLimitCtxKey).(bool); over {
+			w.WriteHeader(code)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// addConnectionCloseHeader is HTTP middleware that, while the server is
+// draining, advertises that keep-alive connections won't be reused, so
+// well-behaved clients open a new connection for their next request.
+func (s *Server) addConnectionCloseHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.draining.Load() {
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Shutdown marks the server as draining -- new connections are refused
+// by wrapListener and in-flight keep-alives are told to close -- then
+// waits for in-flight connections to finish and force-closes the servers
+// (via closeServers) once they have, once DrainTimeout passes, or once
+// ctx is done, whichever comes first. With no DrainTimeout set it
+// force-closes them at once.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+
+	timeout := time.Duration(s.DrainTimeout)
+	if timeout <= 0 {
+		s.closeServers()
+		return nil
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if s.conns.count() == 0 {
+			s.closeServers()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.closeServers()
+			return ctx.Err()
+		case <-deadline.C:
+			s.closeServers()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeServers force-closes the http.Server (and the h3 server, if
+// HTTP/3 is enabled) and releases the resources Provision set up -- the
+// tracer provider and the registered request-duration metric -- so
+// neither leaks across a reload.
+func (s *Server) closeServers() {
+	if s.httpServer != nil {
+		_ = s.httpServer.Close()
+	}
+	if s.h3Server != nil {
+		_ = s.h3Server.Close()
+	}
+	if s.tracerProvider != nil {
+		_ = s.tracerProvider.Shutdown(context.Background())
+	}
+	if s.requestDuration != nil {
+		prometheus.Unregister(s.requestDuration)
+	}
+}
@@ -0,0 +1,155 @@
+package caddyhttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/uptimerobot/caddy/v2"
+)
+
+func TestConnTracker_RejectedAddNeverDecrementsCount(t *testing.T) {
+	tracker := newConnTracker()
+
+	// First connection from this IP succeeds and is registered.
+	release, ok := tracker.tryAdd("1.2.3.4", 0, 1)
+	if !ok {
+		t.Fatal("expected first connection to be admitted")
+	}
+
+	// A second connection from the same IP should be rejected given
+	// maxPerIP=1; tryAdd must hand back no release func for it.
+	rejectedRelease, ok := tracker.tryAdd("1.2.3.4", 0, 1)
+	if ok {
+		t.Fatal("expected second connection to be rejected")
+	}
+	if rejectedRelease != nil {
+		t.Fatal("a rejected connection must not get a release func to call")
+	}
+
+	if got := tracker.count(); got != 1 {
+		t.Fatalf("count() = %d, want 1 (rejection must not touch the tracker)", got)
+	}
+
+	release()
+	if got := tracker.count(); got != 0 {
+		t.Fatalf("count() = %d, want 0 after releasing the one admitted connection", got)
+	}
+}
+
+func TestConnTracker_ReleaseIsIdempotent(t *testing.T) {
+	tracker := newConnTracker()
+
+	release, ok := tracker.tryAdd("1.2.3.4", 0, 0)
+	if !ok {
+		t.Fatal("expected connection to be admitted")
+	}
+
+	release()
+	release() // must not double-decrement
+
+	if got := tracker.count(); got != 0 {
+		t.Fatalf("count() = %d, want 0 after calling release twice", got)
+	}
+}
+
+func TestServer_OverPerIPLimit_FlagsTheConnectionThatExceedsIt(t *testing.T) {
+	srv := &Server{MaxConnectionsPerIP: 1}
+	srv.conns = newConnTracker()
+
+	// The first connection from this IP must not be flagged: checking
+	// overPerIPLimit before registering it (as limitedListener.Accept
+	// does) must see a count of 0, not the post-registration count of 1.
+	if srv.overPerIPLimit("1.2.3.4") {
+		t.Fatal("first connection from an IP must not be flagged as over the per-IP limit")
+	}
+	release, ok := srv.conns.tryAdd("1.2.3.4", 0, 0)
+	if !ok {
+		t.Fatal("expected first connection to be admitted")
+	}
+
+	// Now that one connection is registered, a second must be flagged.
+	if !srv.overPerIPLimit("1.2.3.4") {
+		t.Error("second connection from the same IP must be flagged as over the per-IP limit of 1")
+	}
+
+	release()
+}
+
+func TestTrackedConn_UnwrapExposesUnderlyingConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	tc := &trackedConn{Conn: server, release: func() {}}
+
+	if tc.Unwrap() != server {
+		t.Error("Unwrap must return the wrapped conn, so other listener wrappers' ConnContext can see through trackedConn")
+	}
+}
+
+type stubCtxKeyType struct{}
+
+var stubCtxKey = stubCtxKeyType{}
+
+type stubConnContextWrapper struct{}
+
+func (*stubConnContextWrapper) WrapListener(l net.Listener) net.Listener { return l }
+
+func (*stubConnContextWrapper) ConnContext(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, stubCtxKey, "stub")
+}
+
+func TestServer_ConnContext_CallsListenerWrapperProviders(t *testing.T) {
+	srv := &Server{listenerWrappers: []caddy.ListenerWrapper{&stubConnContextWrapper{}}}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	ctx := srv.ConnContext(context.Background(), server)
+
+	if got, _ := ctx.Value(stubCtxKey).(string); got != "stub" {
+		t.Error("expected Server.ConnContext to call the listener wrapper's ConnContextProvider method")
+	}
+}
+
+func TestServer_EnforceConnectionLimits_RejectsFlaggedRequest(t *testing.T) {
+	srv := &Server{MaxConnectionsPerIPCode: http.StatusTooManyRequests}
+
+	var calledNext bool
+	handler := srv.enforceConnectionLimits(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calledNext = true
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), connLimitCtxKey, true))
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, r)
+
+	if calledNext {
+		t.Error("next handler must not run for a connection over its per-IP limit")
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestServer_EnforceConnectionLimits_AllowsUnflaggedRequest(t *testing.T) {
+	srv := &Server{}
+
+	var calledNext bool
+	handler := srv.enforceConnectionLimits(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calledNext = true
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if !calledNext {
+		t.Error("expected next handler to run for a request with no per-IP limit flag")
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddyhttp
+
+import "sync"
+
+// connTracker counts live connections for a server, in total and per
+// remote IP, so that MaxConnections and MaxConnectionsPerIP can be
+// enforced from the listener's accept loop and from the handler chain.
+type connTracker struct {
+	mu    sync.Mutex
+	total int
+	byIP  map[string]int
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{byIP: make(map[string]int)}
+}
+
+// tryAdd registers a new connection from ip, unless doing so would
+// exceed maxTotal or maxPerIP (a zero limit means unlimited). On
+// success it returns a release func that must be called exactly once,
+// when the connection closes, to free its slot; release is nil when ok
+// is false, since nothing was registered to release.
+func (t *connTracker) tryAdd(ip string, maxTotal, maxPerIP int) (release func(), ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if maxTotal > 0 && t.total >= maxTotal {
+		return nil, false
+	}
+	if maxPerIP > 0 && t.byIP[ip] >= maxPerIP {
+		return nil, false
+	}
+
+	t.total++
+	t.byIP[ip]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { t.remove(ip) })
+	}, true
+}
+
+// remove releases a connection previously registered with tryAdd. It is
+// unexported precisely so it can only be reached through the release
+// func tryAdd hands back, which guarantees it's never called more than
+// once per registered connection (and never for a rejected one).
+func (t *connTracker) remove(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.total--
+	t.byIP[ip]--
+	if t.byIP[ip] <= 0 {
+		delete(t.byIP, ip)
+	}
+}
+
+// count returns the current total number of tracked connections.
+func (t *connTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.total
+}
+
+// perIPCount returns the current number of tracked connections from ip.
+func (t *connTracker) perIPCount(ip string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.byIP[ip]
+}
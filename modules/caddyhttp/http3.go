@@ -0,0 +1,111 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddyhttp
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// QUICConfig holds configuration for tuning the QUIC transport that
+// underlies HTTP/3. It is only consulted when "h3" is among the
+// server's enabled Protocols.
+type QUICConfig struct {
+	// MaxStreams is the maximum number of concurrent bidirectional
+	// streams a QUIC connection may open. A zero value uses the
+	// quic-go default.
+	MaxStreams int `json:"max_streams,omitempty"`
+
+	// InitialStreamWindow is the initial flow-control window, in
+	// bytes, for a single QUIC stream.
+	InitialStreamWindow uint64 `json:"initial_stream_window,omitempty"`
+
+	// InitialConnWindow is the initial flow-control window, in bytes,
+	// for an entire QUIC connection.
+	InitialConnWindow uint64 `json:"initial_conn_window,omitempty"`
+
+	// Disable0RTT disables accepting 0-RTT data on incoming QUIC
+	// connections, trading a round trip for replay safety.
+	Disable0RTT bool `json:"disable_0rtt,omitempty"`
+
+	// AllowEarlyData allows the server to process requests sent as
+	// TLS 1.3 early data before the handshake completes.
+	AllowEarlyData bool `json:"allow_early_data,omitempty"`
+}
+
+// quicConfig builds a quic-go configuration from the user's tuning
+// options. A nil receiver yields quic-go's defaults.
+func (q *QUICConfig) quicConfig() *quic.Config {
+	if q == nil {
+		return &quic.Config{}
+	}
+	return &quic.Config{
+		MaxIncomingStreams:             int64(q.MaxStreams),
+		InitialStreamReceiveWindow:     q.InitialStreamWindow,
+		InitialConnectionReceiveWindow: q.InitialConnWindow,
+		Allow0RTT:                      q.AllowEarlyData && !q.Disable0RTT,
+	}
+}
+
+// enablesH3 reports whether "h3" is among the server's configured
+// protocols.
+func (s *Server) enablesH3() bool {
+	for _, p := range s.Protocols {
+		if p == "h3" {
+			return true
+		}
+	}
+	return false
+}
+
+// listenQUIC binds a UDP listener for HTTP/3 on addr and begins serving
+// handler over QUIC using tlsConfig for the handshake. The returned
+// *http3.Server should be kept so the caller can later call
+// SetQuicHeaders (to have h1/h2 responses advertise Alt-Svc) and Close
+// it down during shutdown.
+func (s *Server) listenQUIC(addr string, tlsConfig *tls.Config, handler http.Handler) (*http3.Server, error) {
+	h3srv := &http3.Server{
+		Addr:       addr,
+		TLSConfig:  tlsConfig,
+		Handler:    handler,
+		QUICConfig: s.QUIC.quicConfig(),
+	}
+
+	go func() {
+		// ListenAndServe blocks until the server is closed; errors at
+		// that point (e.g. "server closed") are expected during a
+		// normal shutdown and are not actionable here.
+		_ = h3srv.ListenAndServe()
+	}()
+
+	return h3srv, nil
+}
+
+// advertiseAltSvc returns middleware that, when h3 is enabled alongside
+// h1/h2, sets the Alt-Svc response header so clients learn they can
+// upgrade to HTTP/3 on a subsequent request.
+func (s *Server) advertiseAltSvc(h3srv *http3.Server) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s.enablesH3() && h3srv != nil {
+				h3srv.SetQuicHeaders(w.Header())
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddyhttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+
+	"github.com/uptimerobot/caddy/v2"
+	"github.com/uptimerobot/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(StaticIPRange{})
+}
+
+// IPRangeSource gets a set of IP ranges that are used to determine whether
+// the address of an incoming connection should be trusted as a proxy, so
+// that the real client IP can be read from forwarding headers. Modules that
+// implement this interface are registered under the "http.ip_sources"
+// namespace.
+type IPRangeSource interface {
+	GetIPRanges(r *http.Request) []netip.Prefix
+}
+
+// StaticIPRange provides a static range of IP addresses, and is able to
+// match IPs it holds. This is the simplest, and default, IPRangeSource.
+type StaticIPRange struct {
+	// RangesRaw is the list of IP ranges, either in CIDR notation or as a
+	// plain IP address, that are statically trusted.
+	RangesRaw []string `json:"ranges,omitempty"`
+
+	ranges []netip.Prefix
+}
+
+// CaddyModule returns the Caddy module information.
+func (StaticIPRange) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.static",
+		New: func() caddy.Module { return new(StaticIPRange) },
+	}
+}
+
+// Provision parses and validates the configured ranges.
+func (s *StaticIPRange) Provision(_ caddy.Context) error {
+	ranges, err := parseIPRanges(s.RangesRaw)
+	if err != nil {
+		return err
+	}
+	s.ranges = ranges
+	return nil
+}
+
+// parseIPRanges parses a list of CIDR ranges or plain IP addresses (which
+// are treated as a CIDR covering just that one address) into prefixes.
+func parseIPRanges(raw []string) ([]netip.Prefix, error) {
+	ranges := make([]netip.Prefix, 0, len(raw))
+	for _, str := range raw {
+		ipOrCIDR := str
+		if ip, err := netip.ParseAddr(str); err == nil {
+			bits := 32
+			if ip.Is6() {
+				bits = 128
+			}
+			ipOrCIDR = fmt.Sprintf("%s/%d", ip.String(), bits)
+		}
+		prefix, err := netip.ParsePrefix(ipOrCIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR address or IP address: %s", str)
+		}
+		ranges = append(ranges, prefix)
+	}
+	return ranges, nil
+}
+
+// GetIPRanges returns the configured ranges, ignoring the request.
+func (s StaticIPRange) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.ranges
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+//
+//	trusted_proxies static <ranges...>
+func (s *StaticIPRange) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume module name
+	s.RangesRaw = d.RemainingArgs()
+	if len(s.RangesRaw) == 0 {
+		return d.ArgErr()
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ IPRangeSource         = (*StaticIPRange)(nil)
+	_ caddy.Provisioner     = (*StaticIPRange)(nil)
+	_ caddyfile.Unmarshaler = (*StaticIPRange)(nil)
+)
@@ -0,0 +1,92 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddyhttp
+
+import (
+	"net/http"
+	"net/netip"
+
+	"github.com/uptimerobot/caddy/v2"
+	"github.com/uptimerobot/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(MatchRemoteIP{})
+}
+
+// RequestMatcher is implemented by types that can match an HTTP request,
+// for use in route matching.
+type RequestMatcher interface {
+	Match(r *http.Request) bool
+}
+
+// MatchRemoteIP matches requests by the client's IP address. It honors
+// the client IP resolved from the server's trusted_proxies/
+// client_ip_headers configuration rather than just the immediate TCP
+// peer, so it still works correctly behind a trusted L4 load balancer.
+type MatchRemoteIP struct {
+	// RangesRaw is the list of IP ranges, either in CIDR notation or as
+	// a plain IP address, to match against.
+	RangesRaw []string `json:"ranges,omitempty"`
+
+	ranges []netip.Prefix
+}
+
+// CaddyModule returns the Caddy module information.
+func (MatchRemoteIP) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.matchers.remote_ip",
+		New: func() caddy.Module { return new(MatchRemoteIP) },
+	}
+}
+
+// Provision parses and validates the configured ranges.
+func (m *MatchRemoteIP) Provision(_ caddy.Context) error {
+	ranges, err := parseIPRanges(m.RangesRaw)
+	if err != nil {
+		return err
+	}
+	m.ranges = ranges
+	return nil
+}
+
+// Match returns true if the request's resolved client IP falls within
+// one of the configured ranges.
+func (m MatchRemoteIP) Match(r *http.Request) bool {
+	ip, err := netip.ParseAddr(ClientIPFromRequest(r))
+	if err != nil {
+		return false
+	}
+	return ipIsTrusted(m.ranges, ip)
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+//
+//	remote_ip <ranges...>
+func (m *MatchRemoteIP) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume matcher name
+	m.RangesRaw = d.RemainingArgs()
+	if len(m.RangesRaw) == 0 {
+		return d.ArgErr()
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ RequestMatcher        = (*MatchRemoteIP)(nil)
+	_ caddy.Provisioner     = (*MatchRemoteIP)(nil)
+	_ caddyfile.Unmarshaler = (*MatchRemoteIP)(nil)
+)
@@ -0,0 +1,116 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddyhttp
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// routeLabelCtxKeyType is the context key under which the matched
+// route/handler name is stashed, so newMetricsMiddleware can label
+// requests by it instead of the raw, unbounded request path.
+type routeLabelCtxKeyType struct{}
+
+var routeLabelCtxKey = routeLabelCtxKeyType{}
+
+// unmatchedRouteLabel is the "handler" label value used for requests
+// that never reached a named route, e.g. 404s and arbitrary scanner
+// traffic -- this is what keeps the metric's cardinality bounded.
+const unmatchedRouteLabel = "unmatched"
+
+// WithRouteLabel records the matched route or handler name on ctx.
+// newMetricsMiddleware reads it from the context of the request it
+// receives, so the label must already be on that request when it
+// reaches the middleware; a label that a handler wrapped by the
+// middleware attaches to its own copy of the request is not seen.
+func WithRouteLabel(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, routeLabelCtxKey, name)
+}
+
+// routeLabel returns the route label recorded via WithRouteLabel for r,
+// or unmatchedRouteLabel if none was set.
+func routeLabel(r *http.Request) string {
+	if name, ok := r.Context().Value(routeLabelCtxKey).(string); ok && name != "" {
+		return name
+	}
+	return unmatchedRouteLabel
+}
+
+// newRequestDuration builds the request-duration histogram described by
+// cfg. If cfg is nil, Prometheus' default buckets are used and no
+// per-host label is added.
+func newRequestDuration(cfg *MetricsConfig) *prometheus.HistogramVec {
+	buckets := prometheus.DefBuckets
+	labels := []string{"handler", "code"}
+
+	if cfg != nil {
+		if len(cfg.Buckets) > 0 {
+			buckets = cfg.Buckets
+		}
+		if cfg.PerHost {
+			labels = append(labels, "host")
+		}
+	}
+
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "caddy",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Histogram of round-trip request durations.",
+		Buckets:   buckets,
+	}, labels)
+}
+
+// newMetricsMiddleware returns HTTP middleware that records each
+// request's duration (and, if Metrics.PerHost is set, its Host header)
+// into requestDuration.
+func (s *Server) newMetricsMiddleware(requestDuration *prometheus.HistogramVec) func(http.Handler) http.Handler {
+	perHost := s.Metrics != nil && s.Metrics.PerHost
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(rec, r)
+
+			labels := prometheus.Labels{
+				"handler": routeLabel(r),
+				"code":    strconv.Itoa(rec.status),
+			}
+			if perHost {
+				labels["host"] = r.Host
+			}
+			requestDuration.With(labels).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// statusRecorder wraps a ResponseWriter to capture the status code
+// written to it, since http.ResponseWriter doesn't expose it directly.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxyprotocol
+
+import (
+	"context"
+	"net"
+
+	proxyproto "github.com/pires/go-proxyproto"
+)
+
+type headerCtxKeyType struct{}
+
+var headerCtxKey = headerCtxKeyType{}
+
+// ConnContext should be installed as the http.Server's ConnContext hook
+// (or invoked by hand by anything else that accepts raw connections) so
+// that the PROXY protocol header -- and therefore the original
+// source/destination and any TLVs -- survives even if another
+// listener_wrappers entry wraps the connection again above this one. A
+// type assertion to *proxyproto.Conn would break the moment that
+// happens, since the conn handed to the HTTP server is no longer our
+// conn directly; walking the wrap chain here and stashing the result in
+// the request's context keeps it reachable regardless of layering.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if header, ok := headerFromConn(c); ok {
+		return context.WithValue(ctx, headerCtxKey, header)
+	}
+	return ctx
+}
+
+// HeaderFromContext returns the PROXY protocol header associated with
+// the connection a request arrived on, if any.
+func HeaderFromContext(ctx context.Context) (*proxyproto.Header, bool) {
+	header, ok := ctx.Value(headerCtxKey).(*proxyproto.Header)
+	return header, ok
+}
+
+// unwrapper is implemented by connections that wrap another connection
+// and expose it, such as tls.Conn (via NetConn) or other listener
+// wrappers that choose to implement it.
+type unwrapper interface {
+	Unwrap() net.Conn
+}
+
+// netConner is implemented by connections such as *tls.Conn that expose
+// the connection underneath them under a different name than Unwrap.
+type netConner interface {
+	NetConn() net.Conn
+}
+
+// headerFromConn walks a (possibly multiply-wrapped) connection looking
+// for a *proxyproto.Conn, and returns its header if found.
+func headerFromConn(c net.Conn) (*proxyproto.Header, bool) {
+	for c != nil {
+		if pc, ok := c.(*proxyproto.Conn); ok {
+			header := pc.ProxyHeader()
+			return header, header != nil
+		}
+		switch u := c.(type) {
+		case unwrapper:
+			c = u.Unwrap()
+		case netConner:
+			c = u.NetConn()
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
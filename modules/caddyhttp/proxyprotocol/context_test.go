@@ -0,0 +1,85 @@
+package proxyprotocol
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	proxyproto "github.com/pires/go-proxyproto"
+)
+
+// wrappingConn simulates another listener_wrapper (e.g. TLS) layered
+// above this one, which only exposes the underlying conn via Unwrap.
+type wrappingConn struct {
+	net.Conn
+	inner net.Conn
+}
+
+func (w *wrappingConn) Unwrap() net.Conn { return w.inner }
+
+func TestHeaderFromConn_SeesThroughWrapping(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		_, _ = client.Write([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 443 80\r\nX"))
+	}()
+
+	pc := proxyproto.NewConn(server)
+	defer pc.Close()
+
+	buf := make([]byte, 1)
+	if _, err := pc.Read(buf); err != nil {
+		t.Fatalf("reading to trigger header parse: %v", err)
+	}
+
+	wrapped := &wrappingConn{Conn: pc, inner: pc}
+
+	header, ok := headerFromConn(wrapped)
+	if !ok {
+		t.Fatal("expected to find a PROXY header through the wrapping conn")
+	}
+	if header.SourceAddr.String() != "1.2.3.4:443" {
+		t.Errorf("source addr = %v, want 1.2.3.4:443", header.SourceAddr)
+	}
+}
+
+func TestHeaderFromConn_NoHeaderForPlainConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	if _, ok := headerFromConn(server); ok {
+		t.Error("expected no PROXY header on a plain net.Conn")
+	}
+}
+
+func TestListenerWrapper_ConnContext_DelegatesToPackageFunc(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		_, _ = client.Write([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 443 80\r\nX"))
+	}()
+
+	pc := proxyproto.NewConn(server)
+	defer pc.Close()
+
+	buf := make([]byte, 1)
+	if _, err := pc.Read(buf); err != nil {
+		t.Fatalf("reading to trigger header parse: %v", err)
+	}
+
+	pp := &ListenerWrapper{}
+	ctx := pp.ConnContext(context.Background(), pc)
+
+	header, ok := HeaderFromContext(ctx)
+	if !ok {
+		t.Fatal("expected a PROXY header recoverable from the context")
+	}
+	if header.SourceAddr.String() != "1.2.3.4:443" {
+		t.Errorf("source addr = %v, want 1.2.3.4:443", header.SourceAddr)
+	}
+}
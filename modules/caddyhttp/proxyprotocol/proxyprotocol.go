@@ -0,0 +1,171 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package proxyprotocol implements a listener wrapper that understands
+// the PROXY protocol (v1 and v2), so that connections arriving through
+// an L4 load balancer still report the real client address.
+package proxyprotocol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	proxyproto "github.com/pires/go-proxyproto"
+
+	"github.com/uptimerobot/caddy/v2"
+	"github.com/uptimerobot/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(ListenerWrapper{})
+}
+
+// ListenerWrapper can be used to accept the PROXY protocol (v1 and v2,
+// including the TLV extensions used by AWS/GCP/Azure load balancers) on
+// a listener, so that the connection's RemoteAddr reflects the original
+// client rather than the upstream load balancer. Select it by name
+// ("proxy_protocol") in a "listener_wrappers" block.
+//
+// Once accepted, the original source/destination and any TLVs are
+// recovered via HeaderFromContext(r.Context()), provided ConnContext is
+// installed as the server's http.Server.ConnContext hook -- that, unlike
+// a direct type assertion on the conn, still works if another
+// listener_wrappers entry wraps this one's connections further.
+type ListenerWrapper struct {
+	// AllowedCIDRs restricts which upstreams may send a PROXY protocol
+	// header. If empty, any upstream is allowed to send one.
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty"`
+
+	// Optional, if true, permits connections that don't send a PROXY
+	// protocol header at all. If false (the default), a connection
+	// that omits the header is rejected.
+	Optional bool `json:"optional,omitempty"`
+
+	// Timeout is how long to wait for the PROXY protocol header to
+	// finish arriving before giving up on the connection. Default: 5s.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	allowedNets []*net.IPNet
+}
+
+// CaddyModule returns the Caddy module information.
+func (ListenerWrapper) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "caddy.listeners.proxy_protocol",
+		New: func() caddy.Module { return new(ListenerWrapper) },
+	}
+}
+
+// Provision sets up the listener wrapper.
+func (pp *ListenerWrapper) Provision(_ caddy.Context) error {
+	for _, cidr := range pp.AllowedCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid allowed CIDR '%s': %v", cidr, err)
+		}
+		pp.allowedNets = append(pp.allowedNets, ipNet)
+	}
+	if pp.Timeout == 0 {
+		pp.Timeout = caddy.Duration(5 * time.Second)
+	}
+	return nil
+}
+
+// WrapListener implements caddy.ListenerWrapper.
+func (pp *ListenerWrapper) WrapListener(l net.Listener) net.Listener {
+	required := proxyproto.REQUIRE
+	if pp.Optional {
+		required = proxyproto.USE
+	}
+
+	return &proxyproto.Listener{
+		Listener: l,
+		Policy: func(upstream net.Addr) (proxyproto.Policy, error) {
+			if len(pp.allowedNets) == 0 {
+				return required, nil
+			}
+			host, _, err := net.SplitHostPort(upstream.String())
+			if err != nil {
+				return proxyproto.REJECT, err
+			}
+			ip := net.ParseIP(host)
+			for _, ipNet := range pp.allowedNets {
+				if ipNet.Contains(ip) {
+					return required, nil
+				}
+			}
+			return proxyproto.REJECT, nil
+		},
+		ReadHeaderTimeout: time.Duration(pp.Timeout),
+	}
+}
+
+// ConnContext implements caddyhttp.ConnContextProvider, so that a
+// caddyhttp.Server's ConnContext hook -- which only gets to install one
+// callback -- recovers this wrapper's PROXY protocol header regardless
+// of whatever else it's composed with. It just delegates to the
+// package-level ConnContext.
+func (pp *ListenerWrapper) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return ConnContext(ctx, c)
+}
+
+// UnmarshalCaddyfile sets up the module from Caddyfile tokens. Syntax:
+//
+//	proxy_protocol {
+//	    allow <cidrs...>
+//	    timeout <duration>
+//	    optional
+//	}
+func (pp *ListenerWrapper) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume wrapper name
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "allow":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			pp.AllowedCIDRs = append(pp.AllowedCIDRs, args...)
+
+		case "timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("parsing timeout duration: %v", err)
+			}
+			pp.Timeout = caddy.Duration(dur)
+
+		case "optional":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			pp.Optional = true
+
+		default:
+			return d.Errf("unrecognized proxy_protocol option '%s'", d.Val())
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*ListenerWrapper)(nil)
+	_ caddy.ListenerWrapper = (*ListenerWrapper)(nil)
+	_ caddyfile.Unmarshaler = (*ListenerWrapper)(nil)
+)
@@ -0,0 +1,198 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/uptimerobot/caddy/v2"
+)
+
+// startWrapped spins up a TCP listener wrapped by the given ListenerWrapper
+// and returns it along with a teardown func.
+func startWrapped(t *testing.T, pp *ListenerWrapper) (net.Listener, func()) {
+	t.Helper()
+	if err := pp.Provision(caddy.Context{}); err != nil {
+		t.Fatalf("provisioning: %v", err)
+	}
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	l := pp.WrapListener(raw)
+	return l, func() { l.Close() }
+}
+
+// acceptErr runs Accept()+a read in the background and reports whatever
+// error (if any) surfaces while consuming the connection.
+func acceptErr(l net.Listener) <-chan error {
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer conn.Close()
+		_, err = bufio.NewReader(conn).ReadByte()
+		errCh <- err
+	}()
+	return errCh
+}
+
+func TestListenerWrapper_MalformedHeader(t *testing.T) {
+	l, teardown := startWrapped(t, &ListenerWrapper{})
+	defer teardown()
+
+	errCh := acceptErr(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer conn.Close()
+
+	// Not a valid PROXY protocol preamble.
+	if _, err := conn.Write([]byte("NOT A PROXY HEADER\r\n")); err != nil {
+		t.Fatalf("writing: %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("expected an error for a malformed PROXY header, got nil")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for malformed header to be rejected")
+	}
+}
+
+func TestListenerWrapper_TruncatedHeader(t *testing.T) {
+	l, teardown := startWrapped(t, &ListenerWrapper{
+		Timeout: caddy.Duration(200 * time.Millisecond),
+	})
+	defer teardown()
+
+	errCh := acceptErr(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer conn.Close()
+
+	// Write a v1 signature but never complete the header.
+	if _, err := conn.Write([]byte("PROXY TCP4 1.2.3.4")); err != nil {
+		t.Fatalf("writing: %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("expected an error for a truncated PROXY header, got nil")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for truncated header to time out")
+	}
+}
+
+func TestListenerWrapper_OptionalAllowsPlainConnections(t *testing.T) {
+	l, teardown := startWrapped(t, &ListenerWrapper{Optional: true})
+	defer teardown()
+
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 5)
+		_, err = conn.Read(buf)
+		errCh <- err
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("writing: %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("expected a plain connection to be accepted, got error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for plain connection to be read")
+	}
+}
+
+// TestListenerWrapper_DisallowedUpstreamRejected is the security
+// boundary the request cared about: a PROXY header from an upstream
+// outside AllowedCIDRs must not be trusted, even if it's well-formed.
+func TestListenerWrapper_DisallowedUpstreamRejected(t *testing.T) {
+	l, teardown := startWrapped(t, &ListenerWrapper{
+		AllowedCIDRs: []string{"10.0.0.0/8"}, // excludes the loopback address tests dial from
+	})
+	defer teardown()
+
+	errCh := acceptErr(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer conn.Close()
+
+	// A well-formed v1 header -- it's the sender that's untrusted, not
+	// the header's shape.
+	if _, err := conn.Write([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 443 80\r\n")); err != nil {
+		t.Fatalf("writing: %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("expected a PROXY header from a disallowed upstream to be rejected, got nil")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for disallowed upstream to be rejected")
+	}
+}
+
+// TestListenerWrapper_AllowedUpstreamAccepted is the converse: an
+// upstream within AllowedCIDRs sending a valid header should be trusted.
+func TestListenerWrapper_AllowedUpstreamAccepted(t *testing.T) {
+	l, teardown := startWrapped(t, &ListenerWrapper{
+		AllowedCIDRs: []string{"127.0.0.1/32"},
+	})
+	defer teardown()
+
+	errCh := acceptErr(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 443 80\r\nX")); err != nil {
+		t.Fatalf("writing: %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("expected a PROXY header from an allowed upstream to be accepted, got error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for allowed upstream's connection to be read")
+	}
+}
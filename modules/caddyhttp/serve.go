@@ -0,0 +1,103 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddyhttp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Serve binds a listener for every address in Listen and begins serving
+// requests through the server's middleware chain.
+//
+// Serve returns once every listener is bound; it does not block for the
+// lifetime of the server, so run it in its own goroutine if the caller
+// has more than one server to start.
+func (s *Server) Serve() error {
+	if len(s.Listen) == 0 {
+		return fmt.Errorf("server has no listen addresses")
+	}
+
+	handler := s.buildHandlerChain(http.NotFoundHandler())
+
+	httpServer := &http.Server{
+		Handler:           handler,
+		ReadTimeout:       time.Duration(s.ReadTimeout),
+		ReadHeaderTimeout: time.Duration(s.ReadHeaderTimeout),
+		WriteTimeout:      time.Duration(s.WriteTimeout),
+		IdleTimeout:       time.Duration(s.IdleTimeout),
+		MaxHeaderBytes:    s.MaxHeaderBytes,
+		ConnContext:       s.ConnContext,
+	}
+	s.httpServer = httpServer
+
+	if s.enablesH3() {
+		h3srv, err := s.listenQUIC(s.Listen[0], nil, handler)
+		if err != nil {
+			return fmt.Errorf("starting QUIC listener: %v", err)
+		}
+		s.h3Server = h3srv
+		httpServer.Handler = s.advertiseAltSvc(h3srv)(handler)
+	}
+
+	for _, addr := range s.Listen {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("listening on %s: %v", addr, err)
+		}
+		ln = s.wrapListeners(ln)
+
+		go func(ln net.Listener) {
+			// Serve blocks until the listener is closed (by Shutdown);
+			// the resulting http.ErrServerClosed is expected at that
+			// point, not an error worth surfacing.
+			_ = httpServer.Serve(ln)
+		}(ln)
+	}
+
+	return nil
+}
+
+// wrapListeners applies every configured listener_wrappers module to ln,
+// in order, followed by the connection limiter. Listener wrappers go on
+// first (innermost) so that the limiter's trackedConn -- which exposes
+// Unwrap -- ends up wrapping them rather than the other way around; that
+// lets ConnContextProvider implementations like proxyprotocol's still
+// walk the chain back to their own conn type.
+func (s *Server) wrapListeners(ln net.Listener) net.Listener {
+	for _, w := range s.listenerWrappers {
+		ln = w.WrapListener(ln)
+	}
+	return s.wrapListener(ln)
+}
+
+// buildHandlerChain wires up Server's middleware, in the order a
+// request actually flows through it: reject requests over the per-IP
+// connection limit and advertise draining before anything else runs,
+// then resolve the client IP, then trace and measure the call into
+// final.
+func (s *Server) buildHandlerChain(final http.Handler) http.Handler {
+	chain := final
+	chain = s.newMetricsMiddleware(s.requestDuration)(chain)
+	if s.tracerProvider != nil {
+		chain = s.newTracingMiddleware(s.tracerProvider, unmatchedRouteLabel)(chain)
+	}
+	chain = s.populateClientIP(chain)
+	chain = s.addConnectionCloseHeader(chain)
+	chain = s.enforceConnectionLimits(chain)
+	return chain
+}
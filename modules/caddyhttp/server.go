@@ -0,0 +1,279 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddyhttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/quic-go/quic-go/http3"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/uptimerobot/caddy/v2"
+)
+
+// Server describes an HTTP server.
+type Server struct {
+	// Listen is the list of addresses (in Caddy network address format)
+	// to bind listeners to.
+	Listen []string `json:"listen,omitempty"`
+
+	// ListenerWrappersRaw is a list of listener wrapper modules, applied
+	// in order, that wrap the server's listeners.
+	ListenerWrappersRaw []json.RawMessage `json:"listener_wrappers,omitempty" caddy:"namespace=caddy.listeners inline_key=wrapper"`
+
+	ReadTimeout       caddy.Duration `json:"read_timeout,omitempty"`
+	ReadHeaderTimeout caddy.Duration `json:"read_header_timeout,omitempty"`
+	WriteTimeout      caddy.Duration `json:"write_timeout,omitempty"`
+	IdleTimeout       caddy.Duration `json:"idle_timeout,omitempty"`
+	MaxHeaderBytes    int            `json:"max_header_bytes,omitempty"`
+
+	AllowH2C          bool  `json:"allow_h2c,omitempty"`
+	ExperimentalHTTP3 bool  `json:"experimental_http3,omitempty"`
+	StrictSNIHost     *bool `json:"strict_sni_host,omitempty"`
+
+	// Protocols is the set of protocols ("h1", "h2", "h2c", "h3")
+	// configured for this server. Serve acts only on "h3", which also
+	// starts HTTP/3 on the first Listen address; the TCP listeners serve
+	// plain HTTP/1.1 whatever else is listed, including when it is empty.
+	Protocols []string `json:"protocols,omitempty"`
+
+	// QUIC tunes the QUIC transport underlying HTTP/3, when "h3" is
+	// among Protocols.
+	QUIC *QUICConfig `json:"quic,omitempty"`
+
+	// TrustedProxiesRaw is the IP range source module that determines
+	// which upstreams are trusted to set client IP forwarding headers.
+	TrustedProxiesRaw json.RawMessage `json:"trusted_proxies,omitempty" caddy:"namespace=http.ip_sources inline_key=source"`
+
+	// ClientIPHeaders is the list of headers, in order of preference,
+	// consulted for the client's real IP once a request's immediate
+	// peer is found among TrustedProxiesRaw's ranges.
+	ClientIPHeaders []string `json:"client_ip_headers,omitempty"`
+
+	Metrics *MetricsConfig `json:"metrics,omitempty"`
+	Tracing *TracingConfig `json:"tracing,omitempty"`
+
+	// DrainTimeout is how long to wait for in-flight connections to
+	// finish during a graceful shutdown before forcibly closing them.
+	DrainTimeout caddy.Duration `json:"drain_timeout,omitempty"`
+
+	// MaxConnections limits the number of concurrent connections this
+	// server will accept. Zero means no limit.
+	MaxConnections int `json:"max_connections,omitempty"`
+
+	// MaxConnectionsPerIP limits the number of concurrent connections
+	// any single client IP may hold open. Zero means no limit.
+	MaxConnectionsPerIP int `json:"max_connections_per_ip,omitempty"`
+
+	// MaxConnectionsPerIPCode is the HTTP status code written when
+	// MaxConnectionsPerIP is exceeded. Default: 429.
+	MaxConnectionsPerIPCode int `json:"max_connections_per_ip_code,omitempty"`
+
+	trustedProxies   IPRangeSource
+	conns            *connTracker
+	draining         atomic.Bool
+	tracerProvider   *sdktrace.TracerProvider
+	requestDuration  *prometheus.HistogramVec
+	httpServer       *http.Server
+	h3Server         *http3.Server
+	listenerWrappers []caddy.ListenerWrapper
+}
+
+// ConnContextProvider is implemented by listener wrapper modules that
+// need to stash per-connection state (e.g. a recovered PROXY protocol
+// header) onto the request context. http.Server only accepts a single
+// ConnContext callback, so Server.ConnContext calls this method on every
+// configured listener_wrappers entry that implements it, rather than
+// each wrapper trying to install its own hook.
+type ConnContextProvider interface {
+	ConnContext(ctx context.Context, c net.Conn) context.Context
+}
+
+// Provision sets up the server's listener wrappers, its trusted-proxy IP
+// source (if any), its tracing and metrics, and its connection tracker.
+func (s *Server) Provision(ctx caddy.Context) error {
+	if s.ListenerWrappersRaw != nil {
+		mods, err := ctx.LoadModule(s, "ListenerWrappersRaw")
+		if err != nil {
+			return fmt.Errorf("loading listener_wrappers modules: %v", err)
+		}
+		for _, mod := range mods.([]interface{}) {
+			wrapper, ok := mod.(caddy.ListenerWrapper)
+			if !ok {
+				return fmt.Errorf("module is not a listener wrapper: %T", mod)
+			}
+			s.listenerWrappers = append(s.listenerWrappers, wrapper)
+		}
+	}
+
+	if s.TrustedProxiesRaw != nil {
+		mod, err := ctx.LoadModule(s, "TrustedProxiesRaw")
+		if err != nil {
+			return fmt.Errorf("loading trusted_proxies module: %v", err)
+		}
+		source, ok := mod.(IPRangeSource)
+		if !ok {
+			return fmt.Errorf("trusted_proxies module is not an IP range source")
+		}
+		s.trustedProxies = source
+	}
+
+	if s.Tracing != nil {
+		tp, err := s.Tracing.tracerProvider(ctx)
+		if err != nil {
+			return fmt.Errorf("setting up tracing: %v", err)
+		}
+		s.tracerProvider = tp
+	}
+
+	s.requestDuration = newRequestDuration(s.Metrics)
+	if err := prometheus.Register(s.requestDuration); err != nil {
+		are, ok := err.(prometheus.AlreadyRegisteredError)
+		if !ok {
+			return fmt.Errorf("registering request duration metric: %v", err)
+		}
+		// Multiple servers share the same metric name and labels; reuse
+		// whichever collector registered first instead of erroring, so
+		// the metric reflects all servers rather than just the last one
+		// provisioned.
+		s.requestDuration = are.ExistingCollector.(*prometheus.HistogramVec)
+	}
+
+	s.conns = newConnTracker()
+	return nil
+}
+
+// clientIPCtxKey is the context key under which the resolved client IP
+// is stored for the lifetime of a request.
+type clientIPCtxKeyType struct{}
+
+var clientIPCtxKey = clientIPCtxKeyType{}
+
+// ClientIPVarKey is the placeholder name, "{http.request.client_ip}",
+// for the resolved client IP. Nothing in this package registers a
+// placeholder under it; the value is read with ClientIPFromRequest.
+const ClientIPVarKey = "http.request.client_ip"
+
+// ClientIPFromRequest returns the client IP that was resolved for r by
+// determineClientIP, falling back to the bare RemoteAddr host if no
+// resolution ever ran (e.g. in tests that construct requests directly).
+func ClientIPFromRequest(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPCtxKey).(string); ok && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// determineClientIP resolves the real client IP for an incoming
+// request: if the request's immediate peer address falls within one of
+// the server's trusted proxy ranges, the first populated header among
+// ClientIPHeaders is walked (via firstUntrustedHop) to find the client
+// IP instead of the peer address.
+func (s *Server) determineClientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+
+	if s.trustedProxies == nil || len(s.ClientIPHeaders) == 0 {
+		return peer
+	}
+
+	peerAddr, err := netip.ParseAddr(peer)
+	if err != nil || !ipIsTrusted(s.trustedProxies.GetIPRanges(r), peerAddr) {
+		return peer
+	}
+
+	ranges := s.trustedProxies.GetIPRanges(r)
+	for _, header := range s.ClientIPHeaders {
+		val := r.Header.Get(header)
+		if val == "" {
+			continue
+		}
+		if ip, ok := firstUntrustedHop(val, ranges); ok {
+			return ip.String()
+		}
+	}
+
+	return peer
+}
+
+// firstUntrustedHop walks a comma-separated forwarding header (as set by
+// X-Forwarded-For) from the right -- the hop closest to us -- skipping
+// entries that themselves fall within ranges, since those are other
+// trusted proxies in the chain rather than the client. It returns the
+// first (right-to-left) entry that isn't trusted.
+//
+// This deliberately does not just take the left-most entry: if a
+// trusted proxy appends to, rather than replaces, an existing
+// X-Forwarded-For, a client can prepend any IP it likes before the
+// request ever reaches that proxy, and a left-most read would hand that
+// forged value straight to {http.request.client_ip} and the remote_ip
+// matcher. Walking from the right and stripping only the hops we
+// actually trust closes that off.
+//
+// If every hop in the header is itself trusted (e.g. an internal chain
+// of load balancers), there's nothing left to strip down to, so the
+// left-most (oldest) hop is returned as the best available answer.
+func firstUntrustedHop(val string, ranges []netip.Prefix) (netip.Addr, bool) {
+	hops := strings.Split(val, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		ip, err := netip.ParseAddr(hop)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		if !ipIsTrusted(ranges, ip) {
+			return ip, true
+		}
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(hops[0]))
+	return addr, err == nil
+}
+
+// ipIsTrusted reports whether ip falls within any of the given ranges.
+func ipIsTrusted(ranges []netip.Prefix, ip netip.Addr) bool {
+	for _, r := range ranges {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// populateClientIP is HTTP middleware that resolves the client IP for
+// each request (honoring TrustedProxiesRaw/ClientIPHeaders) and stores
+// it in the request context, so that later handlers and matchers (like
+// remote_ip) that read it with ClientIPFromRequest see the real client
+// rather than the immediate TCP peer.
+func (s *Server) populateClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := s.determineClientIP(r)
+		ctx := context.WithValue(r.Context(), clientIPCtxKey, ip)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
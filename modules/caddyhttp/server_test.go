@@ -0,0 +1,105 @@
+package caddyhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestServer_DetermineClientIP(t *testing.T) {
+	srv := &Server{
+		trustedProxies:  StaticIPRange{ranges: mustPrefixes(t, "10.0.0.0/8")},
+		ClientIPHeaders: []string{"X-Forwarded-For"},
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		want       string
+	}{
+		{
+			name:       "trusted proxy with forwarded header",
+			remoteAddr: "10.1.2.3:5555",
+			header:     "203.0.113.9, 10.1.2.3",
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "untrusted peer ignores header",
+			remoteAddr: "198.51.100.1:5555",
+			header:     "203.0.113.9",
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "trusted proxy without header falls back to peer",
+			remoteAddr: "10.1.2.3:5555",
+			header:     "",
+			want:       "10.1.2.3",
+		},
+		{
+			// The security boundary the review cared about: a client
+			// that prepends a forged entry before ever reaching the
+			// trusted proxy must not have it taken as the client IP just
+			// because it's left-most. The right-most entry (appended by
+			// the trusted proxy, and therefore the one that matters) is
+			// untrusted and must win instead.
+			name:       "spoofed left-most entry is ignored in favor of the real right-most hop",
+			remoteAddr: "10.1.2.3:5555",
+			header:     "6.6.6.6, 203.0.113.50",
+			want:       "203.0.113.50",
+		},
+		{
+			name:       "chain of entirely trusted proxies falls back to the left-most hop",
+			remoteAddr: "10.1.2.3:5555",
+			header:     "10.1.2.4, 10.1.2.3",
+			want:       "10.1.2.4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				r.Header.Set("X-Forwarded-For", tt.header)
+			}
+
+			got := srv.determineClientIP(r)
+			if got != tt.want {
+				t.Errorf("determineClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_PopulateClientIP(t *testing.T) {
+	srv := &Server{
+		trustedProxies:  StaticIPRange{ranges: mustPrefixes(t, "10.0.0.0/8")},
+		ClientIPHeaders: []string{"X-Forwarded-For"},
+	}
+
+	var sawIP string
+	handler := srv.populateClientIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sawIP = ClientIPFromRequest(r)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.1.2.3:5555"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if sawIP != "203.0.113.9" {
+		t.Errorf("handler saw client IP %q, want %q", sawIP, "203.0.113.9")
+	}
+}
+
+func mustPrefixes(t *testing.T, cidrs ...string) []netip.Prefix {
+	t.Helper()
+	prefixes, err := parseIPRanges(cidrs)
+	if err != nil {
+		t.Fatalf("parsing test CIDRs: %v", err)
+	}
+	return prefixes
+}
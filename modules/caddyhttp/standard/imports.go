@@ -10,6 +10,7 @@ import (
 	_ "github.com/uptimerobot/caddy/v2/modules/caddyhttp/fileserver"
 	_ "github.com/uptimerobot/caddy/v2/modules/caddyhttp/headers"
 	_ "github.com/uptimerobot/caddy/v2/modules/caddyhttp/map"
+	_ "github.com/uptimerobot/caddy/v2/modules/caddyhttp/proxyprotocol"
 	_ "github.com/uptimerobot/caddy/v2/modules/caddyhttp/push"
 	_ "github.com/uptimerobot/caddy/v2/modules/caddyhttp/requestbody"
 	_ "github.com/uptimerobot/caddy/v2/modules/caddyhttp/reverseproxy"
@@ -0,0 +1,153 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddyhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MetricsConfig configures the built-in Prometheus metrics that a
+// server emits for every request it handles.
+type MetricsConfig struct {
+	// PerHost, if true, includes the request's Host header as a label
+	// on HTTP metrics. Enabling this on a server with many hostnames
+	// can significantly increase the cardinality of the metrics.
+	PerHost bool `json:"per_host,omitempty"`
+
+	// Buckets overrides the default set of histogram buckets (in
+	// seconds) used for request duration metrics.
+	Buckets []float64 `json:"buckets,omitempty"`
+}
+
+// TracingConfig configures distributed tracing for a server: every
+// request handled by the server produces a span carrying route and
+// handler attributes, honoring an incoming W3C traceparent header. The
+// span is left on the request context; nothing in this module injects it
+// into upstream requests (reverseproxy's injectTraceContext has no
+// caller).
+type TracingConfig struct {
+	// OtlpEndpoint is the OTLP collector endpoint (HTTP or gRPC) that
+	// spans are exported to.
+	OtlpEndpoint string `json:"otlp_endpoint,omitempty"`
+
+	// Sampler names the sampling strategy to use, e.g. "always_on",
+	// "always_off", or "trace_id_ratio=0.1". Default: "always_on".
+	Sampler string `json:"sampler,omitempty"`
+
+	// ServiceName is the value reported as the OTel "service.name"
+	// resource attribute. Default: "caddy".
+	ServiceName string `json:"service_name,omitempty"`
+}
+
+// tracerProvider builds an OTel SDK TracerProvider that exports spans to
+// OtlpEndpoint, using Sampler and ServiceName to configure sampling and
+// the service.name resource attribute.
+func (t *TracingConfig) tracerProvider(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	serviceName := t.ServiceName
+	if serviceName == "" {
+		serviceName = "caddy"
+	}
+
+	exporter, err := t.otlpExporter(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("setting up OTLP exporter: %v", err)
+	}
+
+	res, err := resource.New(ctx, resource.WithAttributes(
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("building OTel resource: %v", err)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(t.sampler()),
+	), nil
+}
+
+// otlpExporter picks the gRPC or HTTP OTLP trace exporter based on the
+// endpoint's scheme (gRPC is used unless the endpoint is explicitly
+// http:// or https://).
+func (t *TracingConfig) otlpExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	endpoint := t.OtlpEndpoint
+	if strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "https://") {
+		return otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	}
+	return otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithInsecure())
+}
+
+// sampler translates the Sampler string into an OTel SDK Sampler.
+// Recognized forms: "always_on" (default), "always_off", and
+// "trace_id_ratio=<fraction>".
+func (t *TracingConfig) sampler() sdktrace.Sampler {
+	switch {
+	case t.Sampler == "" || t.Sampler == "always_on":
+		return sdktrace.AlwaysSample()
+	case t.Sampler == "always_off":
+		return sdktrace.NeverSample()
+	case strings.HasPrefix(t.Sampler, "trace_id_ratio="):
+		ratio, err := strconv.ParseFloat(strings.TrimPrefix(t.Sampler, "trace_id_ratio="), 64)
+		if err != nil {
+			return sdktrace.AlwaysSample()
+		}
+		return sdktrace.TraceIDRatioBased(ratio)
+	default:
+		return sdktrace.AlwaysSample()
+	}
+}
+
+// newTracingMiddleware returns HTTP middleware that starts a span for
+// every request handled by the server, honoring an incoming W3C
+// traceparent header (so a span started upstream continues here) and
+// making the resulting, possibly-child, span available on the request
+// context for downstream handlers such as reverseproxy to propagate
+// further.
+func (s *Server) newTracingMiddleware(tp trace.TracerProvider, routeHandler string) func(http.Handler) http.Handler {
+	tracer := tp.Tracer("github.com/uptimerobot/caddy/v2/modules/caddyhttp")
+	propagator := propagation.TraceContext{}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+			ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(r.Method),
+					attribute.String("http.route", r.URL.Path),
+					attribute.String("caddy.handler", routeHandler),
+				),
+			)
+			defer span.End()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
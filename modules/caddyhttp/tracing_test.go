@@ -0,0 +1,92 @@
+package caddyhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestServer_TracingMiddleware_PropagatesIncomingTraceparent(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+
+	srv := &Server{}
+	handler := srv.newTracingMiddleware(tp, "subroute")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	const incomingTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	r.Header.Set("traceparent", incomingTraceparent)
+
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	ended := recorder.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("expected 1 span to be recorded, got %d", len(ended))
+	}
+	if got := ended[0].SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("span trace ID = %q, want the trace ID carried by the incoming traceparent", got)
+	}
+}
+
+func TestServer_MetricsMiddleware_RecordsRequestDuration(t *testing.T) {
+	srv := &Server{Metrics: &MetricsConfig{PerHost: true}}
+	hist := newRequestDuration(srv.Metrics)
+
+	handler := srv.newMetricsMiddleware(hist)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/brew", nil)
+	r.Host = "example.com"
+	r = r.WithContext(WithRouteLabel(r.Context(), "brew_route"))
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	m := &dto.Metric{}
+	if err := hist.With(prometheus.Labels{
+		"handler": "brew_route",
+		"code":    "418",
+		"host":    "example.com",
+	}).(prometheus.Histogram).Write(m); err != nil {
+		t.Fatalf("reading histogram: %v", err)
+	}
+	if m.GetHistogram().GetSampleCount() != 1 {
+		t.Errorf("sample count = %d, want 1", m.GetHistogram().GetSampleCount())
+	}
+}
+
+// TestServer_MetricsMiddleware_UnmatchedRequestsShareALabel guards
+// against unbounded cardinality: requests that never reach a named
+// route (like 404s from arbitrary paths) must all collapse onto the
+// same "handler" label rather than one per distinct path.
+func TestServer_MetricsMiddleware_UnmatchedRequestsShareALabel(t *testing.T) {
+	srv := &Server{}
+	hist := newRequestDuration(srv.Metrics)
+	handler := srv.newMetricsMiddleware(hist)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	for _, path := range []string{"/a", "/b/c", "/anything-at-all"} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	m := &dto.Metric{}
+	if err := hist.With(prometheus.Labels{
+		"handler": unmatchedRouteLabel,
+		"code":    "404",
+	}).(prometheus.Histogram).Write(m); err != nil {
+		t.Fatalf("reading histogram: %v", err)
+	}
+	if got := m.GetHistogram().GetSampleCount(); got != 3 {
+		t.Errorf("sample count = %d, want 3 (all 3 unmatched requests should share one label)", got)
+	}
+}